@@ -17,15 +17,23 @@ limitations under the License.
 package openstack
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"k8s.io/kubernetes/pkg/util/exec"
@@ -37,13 +45,144 @@ import (
 // chapter Compute - Networking with nova-network.
 // http://docs.openstack.org/admin-guide-cloud/compute-networking-nova.html#metadata-service
 const metadataUrl = "http://169.254.169.254/"
-const metadataPath = "openstack/2012-08-10/meta_data.json"
-const networkdataPath = "openstack/2015-10-15/network_data.json"
+
+// defaultMetadataVersions is the ordered list of OpenStack metadata API
+// versions probed when MetadataOpts.Versions isn't set, newest first, so a
+// single binary works across Queens-era and current OpenStack. The first
+// version a source actually has the files for wins.
+var defaultMetadataVersions = []string{
+	"2018-08-27",
+	"2017-02-22",
+	"2015-10-15",
+	"2012-08-10",
+}
+
+func metadataPath(version string) string {
+	return "openstack/" + version + "/meta_data.json"
+}
+
+func networkdataPath(version string) string {
+	return "openstack/" + version + "/network_data.json"
+}
+
+// vendor_data.json and its newer, nova-generated-at-boot-time sibling
+// vendor_data2.json carry deployment-specific, free-form data (e.g. the
+// SR-IOV PF/VF mapping or Neutron port details a particular cloud injects)
+// that doesn't fit the fixed network_data.json schema. user_data is whatever
+// blob was passed to `nova boot --user-data`, e.g. a cloud-init script, and
+// is not JSON. All three only ever live under the "latest" alias.
+const vendorDataPath = "openstack/latest/vendor_data.json"
+const vendorData2Path = "openstack/latest/vendor_data2.json"
+const userDataPath = "openstack/latest/user_data"
+
+// Recognized values of MetadataOpts.SearchOrder.
+const (
+	configDriveSource     = "configDrive"
+	metadataServiceSource = "metadataService"
+)
+
+const defaultSearchOrder = configDriveSource + "," + metadataServiceSource
+const defaultRequestTimeout = 5 * time.Second
+const defaultRequestRetries = 3
+
+// metadataDuration wraps time.Duration so cloud.conf's gcfg parser, which
+// only understands strings/ints/bools natively, can populate it via
+// encoding.TextUnmarshaler.
+type metadataDuration struct {
+	time.Duration
+}
+
+func (d *metadataDuration) UnmarshalText(text []byte) error {
+	res, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = res
+	return nil
+}
+
+// MetadataOpts holds the [Metadata] section of cloud.conf: how instance
+// metadata is located and how tolerant to be of a slow or flaky metadata
+// service.
+type MetadataOpts struct {
+	// SearchOrder is a comma separated list of configDriveSource and
+	// metadataServiceSource, tried in order. Defaults to trying the config
+	// drive first, then falling back to the metadata service.
+	SearchOrder string `gcfg:"search-order"`
+	// RequestTimeout is the per-request timeout used when talking to the
+	// metadata service. Defaults to 5s.
+	RequestTimeout metadataDuration `gcfg:"request-timeout"`
+	// RequestRetries is how many times a failed metadata service request
+	// (network error or 5xx) is retried, with jittered exponential backoff
+	// between attempts. Defaults to 3.
+	RequestRetries int `gcfg:"request-retries"`
+	// CAFile is an optional path to a PEM bundle used to validate the
+	// metadata service's certificate, for deployments that serve it over
+	// HTTPS.
+	CAFile string `gcfg:"ca-file"`
+	// Versions is the ordered list of OpenStack metadata API versions to
+	// probe, newest first; may be repeated in cloud.conf, one per line.
+	// Defaults to defaultMetadataVersions, for deployments that only
+	// publish an older or newer version than this package tries by default.
+	Versions []string `gcfg:"versions"`
+	// TTL is how long a cached getMetadata() result is served before it is
+	// considered stale and re-fetched. Zero (the default) never expires the
+	// cache, matching historical behavior.
+	TTL metadataDuration `gcfg:"ttl"`
+}
+
+func (o MetadataOpts) searchOrder() []string {
+	order := o.SearchOrder
+	if order == "" {
+		order = defaultSearchOrder
+	}
+	var sources []string
+	for _, s := range strings.Split(order, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+func (o MetadataOpts) requestTimeout() time.Duration {
+	if o.RequestTimeout.Duration == 0 {
+		return defaultRequestTimeout
+	}
+	return o.RequestTimeout.Duration
+}
+
+func (o MetadataOpts) requestRetries() int {
+	if o.RequestRetries <= 0 {
+		return defaultRequestRetries
+	}
+	return o.RequestRetries
+}
+
+func (o MetadataOpts) versions() []string {
+	if len(o.Versions) == 0 {
+		return defaultMetadataVersions
+	}
+	return o.Versions
+}
 
 // Config drive is defined as an iso9660 or vfat (deprecated) drive
 // with the "config-2" label.
 // http://docs.openstack.org/user-guide/cli-config-drive.html
+//
+// Some deployments (e.g. CoreOS/Flatcar style images using ignition) only
+// mount the config drive once at boot and then unmount it, leaving its
+// contents copied out to configDrivePath on the host. Both the lowercase and
+// uppercase spelling of the label are seen in the wild.
 const configDriveLabel = "config-2"
+const configDriveLabelUpper = "CONFIG-2"
+
+// configDrivePath is where config drive data is expected to already be
+// present on hosts that extract it once at boot instead of leaving the
+// drive mounted for the life of the instance.
+var configDrivePath = "/var/config/openstack"
+
+var configDriveLabels = []string{configDriveLabel, configDriveLabelUpper}
 
 var ErrBadMetadata = errors.New("Invalid OpenStack metadata, got empty uuid")
 
@@ -57,9 +196,28 @@ type Metadata struct {
 }
 
 type Link struct {
-	MAC  string `json:"ethernet_mac_address"`
-	Type string `json:"type"`
-	Id   string `json:"id"`
+	Id    string `json:"id"`
+	VifId string `json:"vif_id"` // Neutron port id; also how a phy/hw_veb link is tied back to its SR-IOV VF
+	Type  string `json:"type"`
+	MAC   string `json:"ethernet_mac_address"`
+	MTU   int    `json:"mtu"`
+
+	// set when Type == "bond"
+	BondMode   string   `json:"bond_mode"`
+	BondLinks  []string `json:"bond_links"`
+	BondMiimon int      `json:"bond_miimon"`
+
+	// set when Type == "vlan"
+	VlanId         int    `json:"vlan_id"`
+	VlanLinkId     string `json:"vlan_link"`
+	VlanMACAddress string `json:"vlan_mac_address"`
+
+	// Parent is the single underlying link for a "vlan" link; Parents are
+	// the member links of a "bond" link. Both are resolved from the ids
+	// above after the full Links list has been parsed, so callers can walk
+	// a bond->phy or vlan->phy chain without doing their own lookups.
+	Parent  *Link   `json:"-"`
+	Parents []*Link `json:"-"`
 }
 
 type Network struct {
@@ -82,6 +240,11 @@ type Networkdata struct {
 	// .. and other fields we don't care about.  Expand as necessary.
 }
 
+// VendorData is the free-form content of vendor_data.json/vendor_data2.json.
+// Its schema varies by deployment, so callers are expected to pick out the
+// keys they care about rather than unmarshal it into a fixed struct.
+type VendorData map[string]json.RawMessage
+
 // parseMetadataUUID reads JSON from OpenStack metadata server and parses
 // instance ID out of it.
 func parseMetadata(r io.Reader) (*Metadata, error) {
@@ -99,141 +262,558 @@ func parseMetadata(r io.Reader) (*Metadata, error) {
 }
 
 func parseNetworkdata(r io.Reader) (*Networkdata, error) {
+	if r == nil {
+		return nil, nil
+	}
+
 	var networkdata Networkdata
 	json := json.NewDecoder(r)
 	if err := json.Decode(&networkdata); err != nil {
 		return nil, err
 	}
-	for _, network := range networkdata.Networks {
-		for _, link := range networkdata.Links {
-			if link.Id == network.LinkId {
-				network.Link = &link
-				break
+
+	linksById := make(map[string]*Link, len(networkdata.Links))
+	for i := range networkdata.Links {
+		linksById[networkdata.Links[i].Id] = &networkdata.Links[i]
+	}
+
+	for i := range networkdata.Networks {
+		networkdata.Networks[i].Link = linksById[networkdata.Networks[i].LinkId]
+	}
+
+	for i := range networkdata.Links {
+		link := &networkdata.Links[i]
+		switch link.Type {
+		case "bond":
+			for _, parentId := range link.BondLinks {
+				if parent, ok := linksById[parentId]; ok {
+					link.Parents = append(link.Parents, parent)
+				}
 			}
+		case "vlan":
+			link.Parent = linksById[link.VlanLinkId]
 		}
 	}
 
 	return &networkdata, nil
 }
 
-func parseFullMetadata(mdReader, ndReader io.Reader) (*Metadata, *Networkdata, error) {
+// parseVendorData decodes a vendor_data.json/vendor_data2.json document. Its
+// contents are deployment-specific, so it is parsed into a free-form map
+// rather than a fixed struct.
+func parseVendorData(r io.Reader) (VendorData, error) {
+	if r == nil {
+		return nil, nil
+	}
+
+	var vd VendorData
+	if err := json.NewDecoder(r).Decode(&vd); err != nil {
+		return nil, err
+	}
+	return vd, nil
+}
+
+// mergeVendorData combines vendor_data.json and vendor_data2.json into a
+// single map, with vendor_data2.json, which Nova generates per-boot, taking
+// precedence over the static vendor_data.json on key collisions.
+func mergeVendorData(vd, vd2 VendorData) VendorData {
+	if vd == nil && vd2 == nil {
+		return nil
+	}
+	merged := make(VendorData, len(vd)+len(vd2))
+	for k, v := range vd {
+		merged[k] = v
+	}
+	for k, v := range vd2 {
+		merged[k] = v
+	}
+	return merged
+}
+
+// parseUserData just slurps up user_data verbatim: unlike the other
+// documents it isn't JSON, it's whatever blob was passed to
+// `nova boot --user-data` (e.g. a cloud-init script).
+func parseUserData(r io.Reader) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return ioutil.ReadAll(r)
+}
+
+func parseFullMetadata(mdReader, ndReader, vdReader, vd2Reader, udReader io.Reader) (*Metadata, *Networkdata, VendorData, []byte, error) {
 	md, err := parseMetadata(mdReader)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	nd, err := parseNetworkdata(ndReader)
 	if err != nil {
 		glog.V(3).Infof("Can't parse network metadatas: %v", err)
 	}
-	return md, nd, nil
+	vd, err := parseVendorData(vdReader)
+	if err != nil {
+		glog.V(3).Infof("Can't parse vendor_data.json: %v", err)
+	}
+	vd2, err := parseVendorData(vd2Reader)
+	if err != nil {
+		glog.V(3).Infof("Can't parse vendor_data2.json: %v", err)
+	}
+	ud, err := parseUserData(udReader)
+	if err != nil {
+		glog.V(3).Infof("Can't read user_data: %v", err)
+	}
+	return md, nd, mergeVendorData(vd, vd2), ud, nil
+}
+
+// metadataFiles bundles the file handles for meta_data.json and the optional
+// per-instance documents alongside it, rooted at a config drive mountpoint
+// or a host path where they were already extracted.
+type metadataFiles struct {
+	metadata    *os.File
+	networkdata *os.File
+	vendordata  *os.File
+	vendordata2 *os.File
+	userdata    *os.File
 }
 
-func getMetadataFromConfigDrive() (*Metadata, *Networkdata, error) {
-	// Try to read instance UUID from config drive.
-	dev := "/dev/disk/by-label/" + configDriveLabel
-	if _, err := os.Stat(dev); os.IsNotExist(err) {
+func (m *metadataFiles) Close() {
+	for _, f := range []*os.File{m.metadata, m.networkdata, m.vendordata, m.vendordata2, m.userdata} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// readMetadataFiles opens meta_data.json for the given API version and, if
+// present, network_data.json, vendor_data.json, vendor_data2.json and
+// user_data rooted at dir. Only meta_data.json is required; the rest are
+// best-effort.
+func readMetadataFiles(dir, version string) (*metadataFiles, error) {
+	mdPath := metadataPath(version)
+	ndPath := networkdataPath(version)
+
+	f, err := os.Open(filepath.Join(dir, mdPath))
+	if err != nil {
+		return nil, err
+	}
+	files := &metadataFiles{metadata: f}
+
+	if f2, err2 := os.Open(filepath.Join(dir, ndPath)); err2 != nil {
+		glog.Warningf("Error reading %s: %v", ndPath, err2)
+	} else {
+		files.networkdata = f2
+	}
+	if f2, err2 := os.Open(filepath.Join(dir, vendorDataPath)); err2 != nil {
+		glog.V(4).Infof("Error reading %s: %v", vendorDataPath, err2)
+	} else {
+		files.vendordata = f2
+	}
+	if f2, err2 := os.Open(filepath.Join(dir, vendorData2Path)); err2 != nil {
+		glog.V(4).Infof("Error reading %s: %v", vendorData2Path, err2)
+	} else {
+		files.vendordata2 = f2
+	}
+	if f2, err2 := os.Open(filepath.Join(dir, userDataPath)); err2 != nil {
+		glog.V(4).Infof("Error reading %s: %v", userDataPath, err2)
+	} else {
+		files.userdata = f2
+	}
+
+	return files, nil
+}
+
+// devDiskByLabelDir is where udev creates by-label device symlinks; a
+// package var so tests can point it at a fixture directory instead of the
+// real /dev.
+var devDiskByLabelDir = "/dev/disk/by-label"
+
+// probeConfigDriveDevice looks for a block device labeled "config-2" (or its
+// uppercase variant), first under /dev/disk/by-label and then by falling
+// back to blkid, which also catches drives udev hasn't created a symlink
+// for yet.
+func probeConfigDriveDevice() (string, error) {
+	for _, label := range configDriveLabels {
+		dev := filepath.Join(devDiskByLabelDir, label)
+		if _, err := os.Stat(dev); err == nil {
+			return dev, nil
+		}
+
 		out, err := exec.New().Command(
 			"blkid", "-l",
-			"-t", "LABEL="+configDriveLabel,
+			"-t", "LABEL="+label,
 			"-o", "device",
 		).CombinedOutput()
 		if err != nil {
-			glog.V(2).Infof("Unable to run blkid: %v", err)
-			return nil, nil, err
+			glog.V(4).Infof("blkid found no device for label %s: %v", label, err)
+			continue
+		}
+		if dev := strings.TrimSpace(string(out)); dev != "" {
+			return dev, nil
 		}
-		dev = strings.TrimSpace(string(out))
+	}
+
+	return "", fmt.Errorf("unable to find a config drive device with label %q or %q", configDriveLabel, configDriveLabelUpper)
+}
+
+// mounterForConfigDrive returns the mount.Interface to use for mounting the
+// config drive. When the kubelet is itself running inside a container, a
+// plain mount only takes effect in the container's own mount namespace, so
+// we need to mount via nsenter into the host's namespace instead.
+func mounterForConfigDrive() mount.Interface {
+	if runningInContainer() {
+		return mount.NewNsenterMounter()
+	}
+	return mount.New("" /* default mount path */)
+}
+
+// runningInContainer reports whether the current process is itself running
+// inside a container, e.g. the hyperkube image.
+func runningInContainer() bool {
+	return os.Getenv("CONTAINERIZED") == "true"
+}
+
+// readMetadataFilesAnyVersion tries each of opts.versions() in turn, newest
+// first, returning the first one for which meta_data.json is found rooted
+// at dir.
+func readMetadataFilesAnyVersion(dir string, opts MetadataOpts) (*metadataFiles, error) {
+	var lastErr error
+	for _, version := range opts.versions() {
+		files, err := readMetadataFiles(dir, version)
+		if err == nil {
+			return files, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func getMetadataFromConfigDrive(mounter mount.Interface, opts MetadataOpts) (*Metadata, *Networkdata, VendorData, []byte, error) {
+	if files, err := readMetadataFilesAnyVersion(configDrivePath, opts); err == nil {
+		glog.V(4).Infof("Found config drive data already present at %s", configDrivePath)
+		defer files.Close()
+		return parseFullMetadata(files.metadata, files.networkdata, files.vendordata, files.vendordata2, files.userdata)
+	}
+
+	dev, err := probeConfigDriveDevice()
+	if err != nil {
+		glog.V(2).Infof("Unable to find config drive device: %v", err)
+		return nil, nil, nil, nil, err
 	}
 
 	mntdir, err := ioutil.TempDir("", "configdrive")
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	defer os.Remove(mntdir)
 
 	glog.V(4).Infof("Attempting to mount configdrive %s on %s", dev, mntdir)
 
-	mounter := mount.New("" /* default mount path */)
 	err = mounter.Mount(dev, mntdir, "iso9660", []string{"ro"})
 	if err != nil {
 		err = mounter.Mount(dev, mntdir, "vfat", []string{"ro"})
 	}
 	if err != nil {
 		glog.Errorf("Error mounting configdrive %s: %v", dev, err)
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
-	defer mounter.Unmount(mntdir)
+	defer func() {
+		if err := mounter.Unmount(mntdir); err != nil {
+			glog.Warningf("Error unmounting configdrive %s: %v", mntdir, err)
+		}
+	}()
 
 	glog.V(4).Infof("Configdrive mounted on %s", mntdir)
 
-	f, err := os.Open(
-		filepath.Join(mntdir, metadataPath))
+	files, err := readMetadataFilesAnyVersion(mntdir, opts)
 	if err != nil {
-		glog.Errorf("Error reading %s on config drive: %v", metadataPath, err)
-		return nil, nil, err
+		glog.Errorf("Error reading meta_data.json on config drive: %v", err)
+		return nil, nil, nil, nil, err
 	}
-	defer f.Close()
-	f2, err2 := os.Open(
-		filepath.Join(mntdir, networkdataPath))
-	if err2 != nil {
-		glog.Warningf("Error reading %s on config drive: %v", networkdataPath, err2)
-		f2 = nil
-	} else {
-		defer f2.Close()
+	defer files.Close()
+
+	return parseFullMetadata(files.metadata, files.networkdata, files.vendordata, files.vendordata2, files.userdata)
+}
+
+// httpClientFor builds the http.Client used to talk to the metadata
+// service, applying the configured request timeout and, if set, a custom
+// CA bundle for HTTPS metadata endpoints.
+func httpClientFor(opts MetadataOpts) (*http.Client, error) {
+	client := &http.Client{Timeout: opts.requestTimeout()}
+
+	if opts.CAFile != "" {
+		caCert, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read metadata service CA file %s: %v", opts.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
 	}
 
-	return parseFullMetadata(f, f2)
+	return client, nil
 }
 
-func getMetadataFromMetadataService() (*Metadata, *Networkdata, error) {
-	// Try to get JSON from metdata server.
-	url := metadataUrl + metadataPath
-	glog.V(4).Infof("Attempting to fetch metadata from %s", url)
-	mdBody, err := get(url)
+func getMetadataFromMetadataService(opts MetadataOpts) (*Metadata, *Networkdata, VendorData, []byte, error) {
+	client, err := httpClientFor(opts)
 	if err != nil {
-		glog.V(3).Infof("Cannot read %s: %v", url, err)
-		return nil, nil, err
+		return nil, nil, nil, nil, err
+	}
+	retries := opts.requestRetries()
+
+	var mdBody, ndBody io.Reader
+	for _, version := range opts.versions() {
+		url := metadataUrl + metadataPath(version)
+		glog.V(4).Infof("Attempting to fetch metadata from %s", url)
+		mdBody, err = get(client, url, retries)
+		if err != nil {
+			glog.V(3).Infof("Cannot read %s: %v", url, err)
+			continue
+		}
+
+		url = metadataUrl + networkdataPath(version)
+		glog.V(4).Infof("Attempting to fetch network data from %s", url)
+		ndBody, err = get(client, url, retries)
+		if err != nil {
+			glog.V(3).Infof("Cannot read %s: %v", url, err)
+		}
+		break
+	}
+	if mdBody == nil {
+		return nil, nil, nil, nil, err
 	}
-	url = metadataUrl + networkdataPath
-	glog.V(4).Infof("Attempting to fetch network data from %s", url)
-	ndBody, err := get(url)
+
+	url := metadataUrl + vendorDataPath
+	glog.V(4).Infof("Attempting to fetch vendor data from %s", url)
+	vdBody, err := get(client, url, retries)
+	if err != nil {
+		glog.V(4).Infof("Cannot read %s: %v", url, err)
+	}
+	url = metadataUrl + vendorData2Path
+	glog.V(4).Infof("Attempting to fetch vendor data from %s", url)
+	vd2Body, err := get(client, url, retries)
+	if err != nil {
+		glog.V(4).Infof("Cannot read %s: %v", url, err)
+	}
+	url = metadataUrl + userDataPath
+	glog.V(4).Infof("Attempting to fetch user data from %s", url)
+	udBody, err := get(client, url, retries)
 	if err != nil {
-		glog.V(3).Infof("Cannot read %s: %v", url, err)
+		glog.V(4).Infof("Cannot read %s: %v", url, err)
 	}
 
-	return parseFullMetadata(mdBody, ndBody)
+	return parseFullMetadata(mdBody, ndBody, vdBody, vd2Body, udBody)
 }
 
-func get(url string) (io.Reader, error) {
-	resp, err := http.Get(url)
+// get fetches url with client, retrying up to retries times with jittered
+// exponential backoff on network errors and 5xx responses. Non-5xx HTTP
+// errors are returned immediately without retrying. On any error it returns
+// a nil io.Reader, which the parseX helpers in parseFullMetadata treat as
+// "document absent" rather than something to decode.
+func get(client *http.Client, url string, retries int) (io.Reader, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Get(url)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("Unexpected status code when reading metadata from %s: %s", url, resp.Status)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.NewReader(body), nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("Unexpected status code when reading metadata from %s: %s", url, resp.Status)
+			resp.Body.Close()
+		}
+
+		if attempt >= retries {
+			break
+		}
+
+		backoff := time.Duration(1<<uint(attempt)) * 100 * time.Millisecond
+		backoff += time.Duration(rand.Int63n(int64(backoff)))
+		glog.V(4).Infof("Retrying metadata request to %s in %v (attempt %d/%d): %v", url, backoff, attempt+1, retries, lastErr)
+		time.Sleep(backoff)
+	}
+
+	glog.V(3).Infof("%v", lastErr)
+	return nil, lastErr
+}
+
+// fetchMetadata fetches meta_data.json and its siblings from the sources
+// named in opts.SearchOrder, in order, without touching any cache.
+func fetchMetadata(opts MetadataOpts) (*Metadata, *Networkdata, VendorData, []byte, error) {
+	var md *Metadata
+	var nd *Networkdata
+	var vd VendorData
+	var ud []byte
+	var err error
+
+	for _, source := range opts.searchOrder() {
+		switch source {
+		case configDriveSource:
+			md, nd, vd, ud, err = getMetadataFromConfigDrive(mounterForConfigDrive(), opts)
+		case metadataServiceSource:
+			md, nd, vd, ud, err = getMetadataFromMetadataService(opts)
+		default:
+			err = fmt.Errorf("unknown metadata search order source: %q", source)
+		}
+		if err == nil {
+			return md, nd, vd, ud, nil
+		}
+	}
+	return nil, nil, nil, nil, err
+}
+
+// metadataStore caches the most recently fetched metadata for the current
+// host. It is safe for concurrent use, can be explicitly invalidated, and
+// can optionally TTL-expire its
+// contents so a long-lived process picks up changes made across a
+// live-migration or Neutron port hotplug instead of serving stale data
+// forever.
+type metadataStore struct {
+	mu sync.RWMutex
+
+	opts MetadataOpts
+	ttl  time.Duration
+
+	metadata    *Metadata
+	networkdata *Networkdata
+	vendordata  VendorData
+	userdata    []byte
+	fetchedAt   time.Time
+}
+
+// defaultMetadataStore is the process-wide cache used by getMetadata and
+// WatchNetworkData.
+var defaultMetadataStore = &metadataStore{}
+
+// Get returns the cached metadata, fetching it first if the cache is empty
+// or has TTL-expired.
+func (s *metadataStore) Get() (*Metadata, *Networkdata, VendorData, []byte, error) {
+	s.mu.RLock()
+	fresh := s.metadata != nil && (s.ttl <= 0 || time.Since(s.fetchedAt) < s.ttl)
+	md, nd, vd, ud := s.metadata, s.networkdata, s.vendordata, s.userdata
+	s.mu.RUnlock()
+
+	if fresh {
+		return md, nd, vd, ud, nil
+	}
+	return s.Refresh(context.Background())
+}
+
+// Refresh unconditionally re-fetches metadata from the configured sources
+// and replaces the cached contents on success, leaving the previous cache
+// in place on failure.
+func (s *metadataStore) Refresh(ctx context.Context) (*Metadata, *Networkdata, VendorData, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	s.mu.RLock()
+	opts := s.opts
+	s.mu.RUnlock()
+
+	md, nd, vd, ud, err := fetchMetadata(opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("Unexpected status code when reading metadata from %s: %s", url, resp.Status)
-		glog.V(3).Infof("%v", err)
-		return nil, err
+	s.mu.Lock()
+	s.metadata, s.networkdata, s.vendordata, s.userdata = md, nd, vd, ud
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+
+	return md, nd, vd, ud, nil
+}
+
+// Invalidate clears the cache, forcing the next Get to re-fetch.
+func (s *metadataStore) Invalidate() {
+	s.mu.Lock()
+	s.metadata = nil
+	s.networkdata = nil
+	s.vendordata = nil
+	s.userdata = nil
+	s.fetchedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func getMetadata(opts MetadataOpts) (*Metadata, *Networkdata, VendorData, []byte, error) {
+	defaultMetadataStore.mu.Lock()
+	defaultMetadataStore.opts = opts
+	defaultMetadataStore.ttl = opts.TTL.Duration
+	defaultMetadataStore.mu.Unlock()
+
+	return defaultMetadataStore.Get()
+}
+
+// hashNetworkdata returns a content hash of nd suitable for change
+// detection; it is not used for anything security sensitive.
+func hashNetworkdata(nd *Networkdata) ([sha256.Size]byte, error) {
+	b, err := json.Marshal(nd)
+	if err != nil {
+		return [sha256.Size]byte{}, err
 	}
-	return resp.Body, nil
+	return sha256.Sum256(b), nil
 }
 
-// Metadata is fixed for the current host, so cache the value process-wide
-var metadataCache *Metadata
-var networkdataCache *Networkdata
+// WatchNetworkData periodically re-reads the metadata source every interval
+// and emits the new Networkdata on the returned channel whenever its
+// content differs from what was last emitted, so callers (e.g. a Neutron
+// link controller) can react to link/network changes across a
+// live-migration or port hotplug without restarting. The channel is closed
+// once ctx is done. interval must be positive; if it isn't, the returned
+// channel is closed immediately instead of panicking inside time.NewTicker.
+func WatchNetworkData(ctx context.Context, interval time.Duration) <-chan *Networkdata {
+	ch := make(chan *Networkdata)
+
+	if interval <= 0 {
+		glog.Errorf("WatchNetworkData: interval must be positive, got %v", interval)
+		close(ch)
+		return ch
+	}
 
-func getMetadata() (*Metadata, *Networkdata, error) {
-	if metadataCache == nil {
-		md, nd, err := getMetadataFromConfigDrive()
-		if err != nil {
-			md, nd, err = getMetadataFromMetadataService()
-		}
-		if err != nil {
-			return nil, nil, err
+	go func() {
+		defer close(ch)
+
+		var lastHash [sha256.Size]byte
+		haveHash := false
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			_, nd, _, _, err := defaultMetadataStore.Refresh(ctx)
+			if err != nil {
+				glog.V(3).Infof("WatchNetworkData: error refreshing metadata: %v", err)
+			} else if nd != nil {
+				hash, err := hashNetworkdata(nd)
+				if err != nil {
+					glog.V(3).Infof("WatchNetworkData: error hashing network data: %v", err)
+				} else if !haveHash || hash != lastHash {
+					lastHash, haveHash = hash, true
+					select {
+					case ch <- nd:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
 		}
-		metadataCache = md
-		networkdataCache = nd
+	}()
 
-	}
-	return metadataCache, networkdataCache, nil
+	return ch
 }
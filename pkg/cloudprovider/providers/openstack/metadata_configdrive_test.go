@@ -0,0 +1,200 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const testVersion = "2018-08-27"
+
+const sampleMetadataJSON = `{"uuid": "83679162-1378-4288-a2d4-70e13ec132aa", "name": "test-server", "availability_zone": "nova"}`
+
+// writeFixture writes meta_data.json (and, if ndJSON is non-empty,
+// network_data.json) for testVersion rooted at dir, mimicking a config
+// drive or host path layout.
+func writeFixture(t *testing.T, dir, mdJSON, ndJSON string) {
+	t.Helper()
+	versionDir := filepath.Join(dir, "openstack", testVersion)
+	if err := os.MkdirAll(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(versionDir, "meta_data.json"), []byte(mdJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ndJSON != "" {
+		if err := ioutil.WriteFile(filepath.Join(versionDir, "network_data.json"), []byte(ndJSON), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withTempDirs points configDrivePath and devDiskByLabelDir at fresh temp
+// dirs for the duration of the test, restoring the originals afterwards.
+func withTempDirs(t *testing.T) (hostPath, labelDir string) {
+	t.Helper()
+	origConfigDrivePath, origLabelDir := configDrivePath, devDiskByLabelDir
+	hostPath = t.TempDir()
+	labelDir = t.TempDir()
+	configDrivePath = hostPath
+	devDiskByLabelDir = labelDir
+	t.Cleanup(func() {
+		configDrivePath, devDiskByLabelDir = origConfigDrivePath, origLabelDir
+	})
+	return hostPath, labelDir
+}
+
+// fakeConfigDriveMounter implements mount.Interface. Mount copies the
+// contents of fixtureDir into target instead of actually mounting anything,
+// so getMetadataFromConfigDrive can be exercised without a real config
+// drive device.
+type fakeConfigDriveMounter struct {
+	fixtureDir string
+	failFstype string // if set, Mount fails for this fstype before succeeding for the next one tried
+
+	mu           sync.Mutex
+	mountCalls   []string
+	unmountCalls []string
+}
+
+func (f *fakeConfigDriveMounter) Mount(source, target, fstype string, options []string) error {
+	f.mu.Lock()
+	f.mountCalls = append(f.mountCalls, fstype)
+	f.mu.Unlock()
+
+	if fstype == f.failFstype {
+		return fmt.Errorf("fake mount failure for fstype %s", fstype)
+	}
+	return copyTree(f.fixtureDir, target)
+}
+
+func (f *fakeConfigDriveMounter) Unmount(target string) error {
+	f.mu.Lock()
+	f.unmountCalls = append(f.unmountCalls, target)
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeConfigDriveMounter) List() ([]mount.MountPoint, error) { return nil, nil }
+func (f *fakeConfigDriveMounter) IsLikelyNotMountPoint(file string) (bool, error) {
+	return true, nil
+}
+func (f *fakeConfigDriveMounter) DeviceOpened(pathname string) (bool, error) { return false, nil }
+func (f *fakeConfigDriveMounter) PathIsDevice(pathname string) (bool, error) { return true, nil }
+func (f *fakeConfigDriveMounter) GetDeviceNameFromMount(mountPath, pluginMountDir string) (string, error) {
+	return "", nil
+}
+func (f *fakeConfigDriveMounter) IsNotMountPoint(file string) (bool, error) { return true, nil }
+
+// copyTree copies the regular files and directories under src into dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, b, 0644)
+	})
+}
+
+func TestGetMetadataFromConfigDrive_PreMounted(t *testing.T) {
+	hostPath, _ := withTempDirs(t)
+	writeFixture(t, hostPath, sampleMetadataJSON, "")
+
+	mounter := &fakeConfigDriveMounter{}
+	md, _, _, _, err := getMetadataFromConfigDrive(mounter, MetadataOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if md.Uuid != "83679162-1378-4288-a2d4-70e13ec132aa" {
+		t.Errorf("got uuid %q", md.Uuid)
+	}
+	if len(mounter.mountCalls) != 0 {
+		t.Errorf("expected no mount to happen when data is already present on the host, got %v", mounter.mountCalls)
+	}
+}
+
+func TestGetMetadataFromConfigDrive_MountRequired(t *testing.T) {
+	_, labelDir := withTempDirs(t)
+	// configDrivePath stays empty, forcing the device probe + mount path.
+	if err := ioutil.WriteFile(filepath.Join(labelDir, configDriveLabel), []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fixtureDir := t.TempDir()
+	writeFixture(t, fixtureDir, sampleMetadataJSON, "")
+
+	mounter := &fakeConfigDriveMounter{fixtureDir: fixtureDir}
+	md, _, _, _, err := getMetadataFromConfigDrive(mounter, MetadataOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if md.Uuid != "83679162-1378-4288-a2d4-70e13ec132aa" {
+		t.Errorf("got uuid %q", md.Uuid)
+	}
+	if len(mounter.mountCalls) == 0 {
+		t.Errorf("expected a mount attempt")
+	}
+	if len(mounter.unmountCalls) != 1 {
+		t.Errorf("expected the config drive to always be unmounted, got %v", mounter.unmountCalls)
+	}
+}
+
+func TestProbeConfigDriveDevice_BothLabels(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string
+	}{
+		{"lowercase", configDriveLabel},
+		{"uppercase", configDriveLabelUpper},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, labelDir := withTempDirs(t)
+			devPath := filepath.Join(labelDir, tt.label)
+			if err := ioutil.WriteFile(devPath, []byte{}, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			dev, err := probeConfigDriveDevice()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if dev != devPath {
+				t.Errorf("got device %q, want %q", dev, devPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNetworkdata_LinkAssociation(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		// expected Network.Link.Id for each entry in networks, in order
+		wantNetworkLinks []string
+		// for each link id, the expected resolved Parent id
+		wantParent map[string]string
+		// for each link id, the expected resolved Parents ids, in order
+		wantParents map[string][]string
+		// for each link id, the expected BondMode/BondMiimon
+		wantBondMode   map[string]string
+		wantBondMiimon map[string]int
+		// for each link id, the expected VlanId/VlanMACAddress
+		wantVlanId         map[string]int
+		wantVlanMACAddress map[string]string
+		// for each link id, the expected VifId
+		wantVifId map[string]string
+	}{
+		{
+			name: "plain",
+			json: `{
+				"links": [{"id": "eth0", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:01"}],
+				"networks": [{"id": "network0", "link": "eth0", "network_id": "net-0", "type": "ipv4_dhcp"}],
+				"services": []
+			}`,
+			wantNetworkLinks: []string{"eth0"},
+		},
+		{
+			name: "bonded",
+			json: `{
+				"links": [
+					{"id": "eth0", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:01"},
+					{"id": "eth1", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:02"},
+					{"id": "bond0", "type": "bond", "ethernet_mac_address": "aa:bb:cc:dd:ee:03", "bond_mode": "802.3ad", "bond_links": ["eth0", "eth1"], "bond_miimon": 100}
+				],
+				"networks": [{"id": "network0", "link": "bond0", "network_id": "net-0", "type": "ipv4_dhcp"}],
+				"services": []
+			}`,
+			wantNetworkLinks: []string{"bond0"},
+			wantParents:      map[string][]string{"bond0": {"eth0", "eth1"}},
+			wantBondMode:     map[string]string{"bond0": "802.3ad"},
+			wantBondMiimon:   map[string]int{"bond0": 100},
+		},
+		{
+			name: "vlan-on-bond",
+			json: `{
+				"links": [
+					{"id": "eth0", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:01"},
+					{"id": "eth1", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:02"},
+					{"id": "bond0", "type": "bond", "ethernet_mac_address": "aa:bb:cc:dd:ee:03", "bond_links": ["eth0", "eth1"]},
+					{"id": "vlan100", "type": "vlan", "ethernet_mac_address": "aa:bb:cc:dd:ee:03", "vlan_id": 100, "vlan_link": "bond0", "vlan_mac_address": "aa:bb:cc:dd:ee:03"}
+				],
+				"networks": [{"id": "network0", "link": "vlan100", "network_id": "net-0", "type": "ipv4_dhcp"}],
+				"services": []
+			}`,
+			wantNetworkLinks:   []string{"vlan100"},
+			wantParent:         map[string]string{"vlan100": "bond0"},
+			wantParents:        map[string][]string{"bond0": {"eth0", "eth1"}},
+			wantVlanId:         map[string]int{"vlan100": 100},
+			wantVlanMACAddress: map[string]string{"vlan100": "aa:bb:cc:dd:ee:03"},
+		},
+		{
+			name: "sriov-vf",
+			json: `{
+				"links": [{"id": "eth0", "type": "hw_veb", "ethernet_mac_address": "aa:bb:cc:dd:ee:01", "vif_id": "port-0"}],
+				"networks": [{"id": "network0", "link": "eth0", "network_id": "net-0", "type": "ipv4_dhcp"}],
+				"services": []
+			}`,
+			wantNetworkLinks: []string{"eth0"},
+			wantVifId:        map[string]string{"eth0": "port-0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nd, err := parseNetworkdata(strings.NewReader(tt.json))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(nd.Networks) != len(tt.wantNetworkLinks) {
+				t.Fatalf("got %d networks, want %d", len(nd.Networks), len(tt.wantNetworkLinks))
+			}
+			for i, network := range nd.Networks {
+				if network.Link == nil {
+					t.Fatalf("network %d: Link was not associated", i)
+				}
+				if network.Link.Id != tt.wantNetworkLinks[i] {
+					t.Errorf("network %d: got link %q, want %q", i, network.Link.Id, tt.wantNetworkLinks[i])
+				}
+			}
+
+			linksById := make(map[string]*Link, len(nd.Links))
+			for i := range nd.Links {
+				linksById[nd.Links[i].Id] = &nd.Links[i]
+			}
+
+			for id, wantParentId := range tt.wantParent {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.Parent == nil || link.Parent.Id != wantParentId {
+					t.Errorf("link %q: got parent %v, want %q", id, link.Parent, wantParentId)
+				}
+			}
+
+			for id, wantParentIds := range tt.wantParents {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if len(link.Parents) != len(wantParentIds) {
+					t.Fatalf("link %q: got %d parents, want %d", id, len(link.Parents), len(wantParentIds))
+				}
+				for i, p := range link.Parents {
+					if p.Id != wantParentIds[i] {
+						t.Errorf("link %q: parent %d: got %q, want %q", id, i, p.Id, wantParentIds[i])
+					}
+				}
+			}
+
+			for id, wantBondMode := range tt.wantBondMode {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.BondMode != wantBondMode {
+					t.Errorf("link %q: got BondMode %q, want %q", id, link.BondMode, wantBondMode)
+				}
+			}
+
+			for id, wantBondMiimon := range tt.wantBondMiimon {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.BondMiimon != wantBondMiimon {
+					t.Errorf("link %q: got BondMiimon %d, want %d", id, link.BondMiimon, wantBondMiimon)
+				}
+			}
+
+			for id, wantVlanId := range tt.wantVlanId {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.VlanId != wantVlanId {
+					t.Errorf("link %q: got VlanId %d, want %d", id, link.VlanId, wantVlanId)
+				}
+			}
+
+			for id, wantVlanMAC := range tt.wantVlanMACAddress {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.VlanMACAddress != wantVlanMAC {
+					t.Errorf("link %q: got VlanMACAddress %q, want %q", id, link.VlanMACAddress, wantVlanMAC)
+				}
+			}
+
+			for id, wantVifId := range tt.wantVifId {
+				link, ok := linksById[id]
+				if !ok {
+					t.Fatalf("missing link %q", id)
+				}
+				if link.VifId != wantVifId {
+					t.Errorf("link %q: got VifId %q, want %q", id, link.VifId, wantVifId)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetadataStore_ConcurrentGetRefresh(t *testing.T) {
+	hostPath, _ := withTempDirs(t)
+	writeFixture(t, hostPath, sampleMetadataJSON, "")
+
+	store := &metadataStore{opts: MetadataOpts{SearchOrder: configDriveSource}}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, _, _, _, err := store.Get(); err != nil {
+				errs <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, _, _, _, err := store.Refresh(context.Background()); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMetadataStore_TTLExpiry(t *testing.T) {
+	hostPath, _ := withTempDirs(t)
+	writeFixture(t, hostPath, sampleMetadataJSON, "")
+
+	store := &metadataStore{
+		opts: MetadataOpts{SearchOrder: configDriveSource},
+		ttl:  10 * time.Millisecond,
+	}
+
+	md, _, _, _, err := store.Get()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if md.Uuid == "" {
+		t.Errorf("expected a uuid in the initial fetch")
+	}
+	fetchedAt := store.fetchedAt
+
+	// Within the TTL, Get must serve the cached value without refetching.
+	if _, _, _, _, err := store.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !store.fetchedAt.Equal(fetchedAt) {
+		t.Errorf("expected cached value to be served within the TTL")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, _, _, _, err := store.Get(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.fetchedAt.Equal(fetchedAt) {
+		t.Errorf("expected the cache to be refreshed once the TTL elapsed")
+	}
+}
+
+func TestWatchNetworkData_InvalidInterval(t *testing.T) {
+	ch := WatchNetworkData(context.Background(), 0)
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Errorf("expected the channel to be closed without emitting a value")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to be closed immediately for a non-positive interval")
+	}
+}
+
+func TestWatchNetworkData_ChangeDetection(t *testing.T) {
+	hostPath, _ := withTempDirs(t)
+	nd1 := `{"links": [{"id": "eth0", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:01"}], "networks": [], "services": []}`
+	writeFixture(t, hostPath, sampleMetadataJSON, nd1)
+
+	origStore := defaultMetadataStore
+	defaultMetadataStore = &metadataStore{opts: MetadataOpts{SearchOrder: configDriveSource}}
+	defer func() { defaultMetadataStore = origStore }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := WatchNetworkData(ctx, 5*time.Millisecond)
+
+	select {
+	case nd := <-ch:
+		if len(nd.Links) != 1 || nd.Links[0].Id != "eth0" {
+			t.Fatalf("unexpected network data: %+v", nd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the initial network data")
+	}
+
+	// Rewriting with different content must produce a second emission.
+	nd2 := `{"links": [{"id": "eth1", "type": "phy", "ethernet_mac_address": "aa:bb:cc:dd:ee:02"}], "networks": [], "services": []}`
+	writeFixture(t, hostPath, sampleMetadataJSON, nd2)
+
+	select {
+	case nd := <-ch:
+		if len(nd.Links) != 1 || nd.Links[0].Id != "eth1" {
+			t.Fatalf("unexpected network data after change: %+v", nd)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the changed network data")
+	}
+}